@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// generatorVersion is bumped whenever a change to the rendering pipeline
+// (markdown.go, templates, front matter handling) would produce different
+// output for unchanged input, so stale cache entries get invalidated.
+const generatorVersion = "2"
+
+// buildCache maps a post's source path to the hash of its last successful
+// render, so writePosts can skip re-rendering posts that haven't changed.
+// It's safe for concurrent use by the -jobs worker pool.
+type buildCache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	Hash       string `json:"hash"`
+	OutputPath string `json:"output_path"`
+}
+
+func buildCachePath(blogDir string) string {
+	return filepath.Join(blogDir, ".build-cache.json")
+}
+
+func loadBuildCache(blogDir string) (*buildCache, error) {
+	path := buildCachePath(blogDir)
+	c := &buildCache{path: path, entries: map[string]cacheEntry{}}
+
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &c.entries); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *buildCache) get(sourcePath string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[sourcePath]
+	return entry, ok
+}
+
+func (c *buildCache) set(sourcePath, hash, outputPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[sourcePath] = cacheEntry{Hash: hash, OutputPath: outputPath}
+}
+
+func (c *buildCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, err := json.MarshalIndent(c.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, fs.FileMode(0o644))
+}
+
+// contentHash hashes a post's raw markdown together with the embedded
+// templates' digest, its effective highlight style, and generatorVersion,
+// so edits to the post, the rendering pipeline, or the chroma theme used to
+// render it (which changes the inlined per-token colors in its output)
+// invalidate the cache entry.
+func contentHash(raw []byte, templatesDigest, highlightStyle string) string {
+	h := sha256.New()
+	h.Write(raw)
+	fmt.Fprintf(h, "|%s|%s|%s", templatesDigest, highlightStyle, generatorVersion)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// templatesDigest hashes the content of every embedded template, standing
+// in for a template mtime: embed.FS pins all files to a fixed ModTime, so
+// only the bytes themselves can signal that a template changed.
+func templatesDigest() (string, error) {
+	matches, err := fs.Glob(templateFS, "templates/*.tmpl")
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(matches)
+
+	h := sha256.New()
+	for _, name := range matches {
+		data, err := templateFS.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
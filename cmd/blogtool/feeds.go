@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// site holds the metadata feeds (and eventually the sitemap) need but a
+// single post doesn't carry: the site's title, default author, base URL,
+// and language. It's assembled from -site-* flags, optionally overridden by
+// a blogDir/site.yml config file.
+type site struct {
+	Title  string
+	Author string
+	URL    string
+	Lang   string
+}
+
+// loadSite builds a site from the given flag values, then overlays any
+// blogDir/site.yml found on disk. The config file is optional; flags alone
+// are enough to run.
+func loadSite(blogDir, url, title, author, lang string) (site, error) {
+	s := site{Title: title, Author: author, URL: strings.TrimSuffix(url, "/"), Lang: lang}
+
+	cfgPath := filepath.Join(blogDir, "site.yml")
+	raw, err := os.ReadFile(cfgPath)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+
+	var cfg struct {
+		Title  string `yaml:"title"`
+		Author string `yaml:"author"`
+		URL    string `yaml:"url"`
+		Lang   string `yaml:"lang"`
+	}
+	if err := yaml.Unmarshal(raw, &cfg); err != nil {
+		return s, fmt.Errorf("parse %s: %w", cfgPath, err)
+	}
+	if cfg.Title != "" {
+		s.Title = cfg.Title
+	}
+	if cfg.Author != "" {
+		s.Author = cfg.Author
+	}
+	if cfg.URL != "" && s.URL == "" {
+		s.URL = strings.TrimSuffix(cfg.URL, "/")
+	}
+	if cfg.Lang != "" {
+		s.Lang = cfg.Lang
+	}
+	return s, nil
+}
+
+// absHref resolves a site-root-relative path like "blog/feed.xml" against
+// s.URL. With no base URL configured it's returned unchanged.
+func (s site) absHref(href string) string {
+	if s.URL == "" {
+		return href
+	}
+	return s.URL + "/" + href
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  *atomAuthor `xml:"author,omitempty"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  *atomAuthor `xml:"author,omitempty"`
+	Summary string      `xml:"summary"`
+	Content atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",cdata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Language    string    `xml:"language,omitempty"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Description string `xml:"description"`
+	Author      string `xml:"author,omitempty"`
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url,omitempty"`
+	FeedURL     string         `json:"feed_url,omitempty"`
+	Language    string         `json:"language,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string           `json:"id"`
+	URL           string           `json:"url,omitempty"`
+	Title         string           `json:"title"`
+	ContentHTML   string           `json:"content_html"`
+	Summary       string           `json:"summary,omitempty"`
+	DatePublished string           `json:"date_published,omitempty"`
+	Authors       []jsonFeedAuthor `json:"authors,omitempty"`
+	Tags          []string         `json:"tags,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+// writeFeeds emits blog/feed.xml (Atom 1.0), blog/rss.xml (RSS 2.0), and
+// blog/feed.json (JSON Feed 1.1) from the sorted posts.
+func writeFeeds(blogDir string, posts []*post, s site) error {
+	if err := writeAtomFeed(filepath.Join(blogDir, "feed.xml"), posts, s); err != nil {
+		return err
+	}
+	if err := writeRSSFeed(filepath.Join(blogDir, "rss.xml"), posts, s); err != nil {
+		return err
+	}
+	return writeJSONFeedFile(filepath.Join(blogDir, "feed.json"), posts, s)
+}
+
+func writeAtomFeed(path string, posts []*post, s site) error {
+	feed := atomFeed{
+		Title:   s.Title,
+		ID:      s.absHref("blog/"),
+		Updated: feedUpdated(posts).Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: s.absHref("blog/feed.xml"), Rel: "self", Type: "application/atom+xml"},
+			{Href: s.absHref("blog/"), Rel: "alternate", Type: "text/html"},
+		},
+	}
+	if s.Author != "" {
+		feed.Author = &atomAuthor{Name: s.Author}
+	}
+	for _, p := range posts {
+		entry := atomEntry{
+			Title:   p.Title,
+			ID:      s.absHref("blog/" + p.Href),
+			Updated: p.Date.Format(time.RFC3339),
+			Links:   []atomLink{{Href: s.absHref("blog/" + p.Href), Rel: "alternate", Type: "text/html"}},
+			Summary: p.Excerpt,
+			Content: atomContent{Type: "html", Body: string(p.Content)},
+		}
+		if p.AuthorLine != "" {
+			entry.Author = &atomAuthor{Name: p.AuthorLine}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return writeXML(path, feed)
+}
+
+func writeRSSFeed(path string, posts []*post, s site) error {
+	channel := rssChannel{
+		Title:       s.Title,
+		Link:        s.absHref("blog/"),
+		Description: s.Title,
+		Language:    s.Lang,
+	}
+	for _, p := range posts {
+		channel.Items = append(channel.Items, rssItem{
+			Title:       p.Title,
+			Link:        s.absHref("blog/" + p.Href),
+			GUID:        s.absHref("blog/" + p.Href),
+			PubDate:     p.Date.Format(time.RFC1123Z),
+			Description: p.Excerpt,
+			Author:      p.AuthorLine,
+		})
+	}
+	return writeXML(path, rssFeed{Version: "2.0", Channel: channel})
+}
+
+func writeJSONFeedFile(path string, posts []*post, s site) error {
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       s.Title,
+		HomePageURL: s.absHref("blog/"),
+		FeedURL:     s.absHref("blog/feed.json"),
+		Language:    s.Lang,
+	}
+	for _, p := range posts {
+		item := jsonFeedItem{
+			ID:            s.absHref("blog/" + p.Href),
+			URL:           s.absHref("blog/" + p.Href),
+			Title:         p.Title,
+			ContentHTML:   string(p.Content),
+			Summary:       p.Excerpt,
+			DatePublished: p.Date.Format(time.RFC3339),
+			Tags:          p.Tags,
+		}
+		for _, author := range p.Authors {
+			item.Authors = append(item.Authors, jsonFeedAuthor{Name: author})
+		}
+		feed.Items = append(feed.Items, item)
+	}
+
+	data, err := json.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, fs.FileMode(0o644))
+}
+
+func writeXML(path string, v any) error {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.Write(data)
+	buf.WriteByte('\n')
+	return os.WriteFile(path, buf.Bytes(), fs.FileMode(0o644))
+}
+
+// feedUpdated returns the feed's overall last-updated time: the newest
+// post's date, since posts are sorted newest-first.
+func feedUpdated(posts []*post) time.Time {
+	if len(posts) == 0 {
+		return time.Now()
+	}
+	return posts[0].Date
+}
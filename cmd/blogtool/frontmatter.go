@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// frontMatter wraps the parsed YAML document of a post's front matter. It
+// preserves the original nested structure (map[string]any) so templates and
+// callers can reach into richer metadata like a nested `seo:` block, while
+// still offering the flat scalar()/list() API older call sites expect.
+type frontMatter struct {
+	data map[string]any
+}
+
+func newFrontMatter() frontMatter {
+	return frontMatter{data: map[string]any{}}
+}
+
+// has reports whether key was set in the front matter at all, which lets
+// callers distinguish "absent" from "present but empty".
+func (fm frontMatter) has(key string) bool {
+	_, ok := fm.data[strings.ToLower(key)]
+	return ok
+}
+
+// scalar flattens key's value to a string, covering the common YAML scalar
+// kinds (string, bool, number, date) a post's front matter might use.
+func (fm frontMatter) scalar(key string) string {
+	return flattenScalar(fm.data[strings.ToLower(key)])
+}
+
+// list flattens key's value to a string slice. Non-sequence values yield nil.
+func (fm frontMatter) list(key string) []string {
+	items, ok := fm.data[strings.ToLower(key)].([]any)
+	if !ok {
+		return nil
+	}
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		if s := flattenScalar(item); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// bool reports key's boolean value, e.g. `draft: true` or `toc: true`.
+func (fm frontMatter) bool(key string) bool {
+	v, _ := fm.data[strings.ToLower(key)].(bool)
+	return v
+}
+
+// time parses key's value as a date, accepting both a native YAML date/time
+// and a plain "2006-01-02" string.
+func (fm frontMatter) time(key string) (time.Time, bool) {
+	switch v := fm.data[strings.ToLower(key)].(type) {
+	case time.Time:
+		return v, true
+	case string:
+		if t, err := time.Parse("2006-01-02", v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// mapVal returns key's value as a nested map, e.g. a `seo:` block.
+func (fm frontMatter) mapVal(key string) map[string]any {
+	v, _ := fm.data[strings.ToLower(key)].(map[string]any)
+	return v
+}
+
+func flattenScalar(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case bool:
+		return strconv.FormatBool(val)
+	case int:
+		return strconv.Itoa(val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case time.Time:
+		return val.Format("2006-01-02")
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// extractFrontMatter splits raw into its leading `---`-delimited YAML front
+// matter and the remaining Markdown body.
+func extractFrontMatter(raw string) (frontMatter, string, error) {
+	raw = strings.TrimLeft(raw, "\ufeff")
+	if !strings.HasPrefix(raw, "---") {
+		return newFrontMatter(), strings.TrimSpace(raw), nil
+	}
+
+	remainder := raw[3:]
+	remainder = strings.TrimPrefix(remainder, "\r")
+	remainder = strings.TrimPrefix(remainder, "\n")
+
+	lines := strings.Split(remainder, "\n")
+	boundary := -1
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "---" {
+			boundary = i
+			break
+		}
+	}
+	if boundary == -1 {
+		return newFrontMatter(), raw, fmt.Errorf("missing closing front matter delimiter")
+	}
+
+	meta := strings.Join(lines[:boundary], "\n")
+	body := strings.Join(lines[boundary+1:], "\n")
+
+	fm, err := parseFrontMatter(meta)
+	if err != nil {
+		return fm, "", err
+	}
+
+	return fm, strings.TrimSpace(body), nil
+}
+
+// parseFrontMatter parses a YAML document into a frontMatter, lower-casing
+// its top-level keys so scalar()/list()/bool()/time()/mapValue() lookups stay
+// case-insensitive. Malformed YAML is reported with yaml.v3's own line-number
+// information intact.
+func parseFrontMatter(raw string) (frontMatter, error) {
+	var data map[string]any
+	if err := yaml.Unmarshal([]byte(raw), &data); err != nil {
+		return newFrontMatter(), fmt.Errorf("parse front matter: %w", err)
+	}
+
+	lowered := make(map[string]any, len(data))
+	for key, value := range data {
+		lowered[strings.ToLower(key)] = value
+	}
+	return frontMatter{data: lowered}, nil
+}
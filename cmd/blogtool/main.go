@@ -3,10 +3,8 @@ package main
 import (
 	"bytes"
 	"embed"
-	"errors"
 	"flag"
 	"fmt"
-	"html"
 	"html/template"
 	"io/fs"
 	"os"
@@ -15,6 +13,16 @@ import (
 	"strings"
 	"time"
 	"unicode"
+
+	"github.com/yuin/goldmark"
+)
+
+// defaultSitemapPriority and defaultSitemapChangefreq are used when neither
+// -sitemap-priority/-sitemap-changefreq nor a post's `sitemap` front matter
+// override them.
+const (
+	defaultSitemapPriority   = "0.5"
+	defaultSitemapChangefreq = "monthly"
 )
 
 type post struct {
@@ -31,6 +39,11 @@ type post struct {
 	SourcePath string
 	OutputPath string
 	Href       string
+
+	SitemapPriority   string
+	SitemapChangefreq string
+
+	HighlightStyle string
 }
 
 //go:embed templates/*.tmpl
@@ -41,17 +54,47 @@ var (
 		"safe": func(s string) template.HTML {
 			return template.HTML(escapeText(s))
 		},
+		"tagSlug": slugifyText,
 	}
 	postTpl  = template.Must(template.New("post.tmpl").Funcs(templateFuncs).ParseFS(templateFS, "templates/post.tmpl"))
 	indexTpl = template.Must(template.New("index.tmpl").Funcs(templateFuncs).ParseFS(templateFS, "templates/index.tmpl"))
 )
 
 func main() {
-	var blogDir string
-	flag.StringVar(&blogDir, "blog", "blog", "directory containing blog markdown files")
-	flag.Parse()
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "serve" {
+		if err := runServe(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "serve: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	runBuild(args)
+}
 
-	posts, err := loadPosts(blogDir)
+func runBuild(args []string) {
+	fset := flag.NewFlagSet("blogtool", flag.ExitOnError)
+	var blogDir string
+	var highlightStyle string
+	var siteURL, siteTitle, siteAuthor, siteLang string
+	var sitemapPriority, sitemapChangefreq string
+	var force bool
+	var jobs int
+	fset.StringVar(&blogDir, "blog", "blog", "directory containing blog markdown files")
+	fset.StringVar(&highlightStyle, "highlight-style", defaultHighlightStyle, "chroma theme used to highlight fenced code blocks")
+	fset.StringVar(&siteURL, "site-url", "", "absolute base URL of the site, e.g. https://example.com (used for feed/sitemap links)")
+	fset.StringVar(&siteTitle, "site-title", "Blog", "site title used in the generated feeds")
+	fset.StringVar(&siteAuthor, "site-author", "", "default author name used in the generated feeds")
+	fset.StringVar(&siteLang, "site-lang", "en", "site language code used in the generated feeds")
+	fset.StringVar(&sitemapPriority, "sitemap-priority", defaultSitemapPriority, "default sitemap <priority>, overridable per post via sitemap.priority front matter")
+	fset.StringVar(&sitemapChangefreq, "sitemap-changefreq", defaultSitemapChangefreq, "default sitemap <changefreq>, overridable per post via sitemap.changefreq front matter")
+	fset.BoolVar(&force, "force", false, "re-render every post, ignoring the build cache")
+	fset.IntVar(&jobs, "jobs", 1, "number of posts to parse/render concurrently")
+	fset.Parse(args)
+
+	md := newMarkdown(highlightStyle)
+
+	posts, err := loadPosts(blogDir, md, jobs, sitemapPriority, sitemapChangefreq, highlightStyle)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to load posts: %v\n", err)
 		os.Exit(1)
@@ -61,30 +104,62 @@ func main() {
 		return
 	}
 
-	if err := writePosts(posts); err != nil {
+	s, err := loadSite(blogDir, siteURL, siteTitle, siteAuthor, siteLang)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load site config: %v\n", err)
+		os.Exit(1)
+	}
+
+	cache, err := loadBuildCache(blogDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load build cache: %v\n", err)
+		os.Exit(1)
+	}
+	if err := writePosts(posts, cache, force, jobs); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to write posts: %v\n", err)
 		os.Exit(1)
 	}
+	if err := cache.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save build cache: %v\n", err)
+		os.Exit(1)
+	}
+
 	if err := writeIndex(blogDir, posts); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to write blog index: %v\n", err)
 		os.Exit(1)
 	}
+	if err := writeFeeds(blogDir, posts, s); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write feeds: %v\n", err)
+		os.Exit(1)
+	}
+
+	tags, err := writeTagPages(blogDir, posts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write tag pages: %v\n", err)
+		os.Exit(1)
+	}
+	if err := writeSitemap(blogDir, posts, tags, s, sitemapPriority, sitemapChangefreq); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write sitemap: %v\n", err)
+		os.Exit(1)
+	}
 }
 
-func loadPosts(blogDir string) ([]*post, error) {
+func loadPosts(blogDir string, md goldmark.Markdown, jobs int, defaultSitemapPriority, defaultSitemapChangefreq, defaultHighlightStyle string) ([]*post, error) {
 	mdFiles, err := filepath.Glob(filepath.Join(blogDir, "*.md"))
 	if err != nil {
 		return nil, err
 	}
 	sort.Strings(mdFiles)
 
-	var posts []*post
-	for _, mdPath := range mdFiles {
-		p, err := parsePost(blogDir, mdPath)
+	posts, err := parallelMap(jobs, mdFiles, func(mdPath string) (*post, error) {
+		p, err := parsePost(blogDir, mdPath, md, defaultSitemapPriority, defaultSitemapChangefreq, defaultHighlightStyle)
 		if err != nil {
 			return nil, fmt.Errorf("parse %s: %w", mdPath, err)
 		}
-		posts = append(posts, p)
+		return p, nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	sort.SliceStable(posts, func(i, j int) bool {
@@ -96,7 +171,7 @@ func loadPosts(blogDir string) ([]*post, error) {
 	return posts, nil
 }
 
-func parsePost(blogDir, path string) (*post, error) {
+func parsePost(blogDir, path string, md goldmark.Markdown, defaultSitemapPriority, defaultSitemapChangefreq, defaultHighlightStyle string) (*post, error) {
 	raw, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
@@ -107,7 +182,15 @@ func parsePost(blogDir, path string) (*post, error) {
 		return nil, err
 	}
 
-	content, firstParagraph, headingTitle := markdownToHTML(body)
+	highlightStyle := defaultHighlightStyle
+	if style := fm.scalar("highlight"); style != "" {
+		highlightStyle = style
+		md = newMarkdown(style)
+	}
+	content, firstParagraph, headingTitle, err := renderMarkdown(md, body, fm.bool("toc"))
+	if err != nil {
+		return nil, err
+	}
 
 	slug := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
 	title := fm.scalar("title")
@@ -122,12 +205,12 @@ func parsePost(blogDir, path string) (*post, error) {
 	tags := fm.list("tags")
 
 	var date time.Time
-	if ds := fm.scalar("date"); ds != "" {
-		if parsed, err := time.Parse("2006-01-02", ds); err == nil {
-			date = parsed
-		} else {
-			return nil, fmt.Errorf("invalid date %q: %w", ds, err)
+	if fm.has("date") {
+		parsed, ok := fm.time("date")
+		if !ok {
+			return nil, fmt.Errorf("invalid date %v", fm.scalar("date"))
 		}
+		date = parsed
 	} else {
 		info, statErr := os.Stat(path)
 		if statErr == nil {
@@ -142,31 +225,69 @@ func parsePost(blogDir, path string) (*post, error) {
 		excerpt = makeExcerpt(body, 220)
 	}
 
+	sitemapPriority := defaultSitemapPriority
+	sitemapChangefreq := defaultSitemapChangefreq
+	if sm := fm.mapVal("sitemap"); sm != nil {
+		if v, ok := sm["priority"]; ok {
+			sitemapPriority = flattenScalar(v)
+		}
+		if v, ok := sm["changefreq"]; ok {
+			sitemapChangefreq = flattenScalar(v)
+		}
+	}
+
 	p := &post{
-		Title:      title,
-		Slug:       slug,
-		Authors:    authors,
-		AuthorLine: formatAuthors(authors),
-		Tags:       tags,
-		Date:       date,
-		DateISO:    date.Format("2006-01-02"),
-		DateHuman:  date.Format("January 2, 2006"),
-		Content:    content,
-		Excerpt:    excerpt,
-		SourcePath: path,
-		OutputPath: filepath.Join(blogDir, slug+".html"),
-		Href:       slug + ".html",
+		Title:             title,
+		Slug:              slug,
+		Authors:           authors,
+		AuthorLine:        formatAuthors(authors),
+		Tags:              tags,
+		Date:              date,
+		DateISO:           date.Format("2006-01-02"),
+		DateHuman:         date.Format("January 2, 2006"),
+		Content:           content,
+		Excerpt:           excerpt,
+		SourcePath:        path,
+		OutputPath:        filepath.Join(blogDir, slug+".html"),
+		Href:              slug + ".html",
+		SitemapPriority:   sitemapPriority,
+		SitemapChangefreq: sitemapChangefreq,
+		HighlightStyle:    highlightStyle,
 	}
 	return p, nil
 }
 
-func writePosts(posts []*post) error {
-	for _, p := range posts {
+// writePosts renders each post to its OutputPath, skipping any post whose
+// content hash already matches cache and whose output file still exists.
+// force bypasses the cache entirely; jobs controls render concurrency.
+func writePosts(posts []*post, cache *buildCache, force bool, jobs int) error {
+	digest, err := templatesDigest()
+	if err != nil {
+		return err
+	}
+
+	_, err = parallelMap(jobs, posts, func(p *post) (struct{}, error) {
+		raw, err := os.ReadFile(p.SourcePath)
+		if err != nil {
+			return struct{}{}, err
+		}
+		hash := contentHash(raw, digest, p.HighlightStyle)
+
+		if !force {
+			if entry, ok := cache.get(p.SourcePath); ok && entry.Hash == hash && entry.OutputPath == p.OutputPath {
+				if _, statErr := os.Stat(p.OutputPath); statErr == nil {
+					return struct{}{}, nil
+				}
+			}
+		}
+
 		if err := renderTemplate(postTpl, p.OutputPath, p); err != nil {
-			return err
+			return struct{}{}, err
 		}
-	}
-	return nil
+		cache.set(p.SourcePath, hash, p.OutputPath)
+		return struct{}{}, nil
+	})
+	return err
 }
 
 func writeIndex(blogDir string, posts []*post) error {
@@ -187,271 +308,6 @@ func renderTemplate(tpl *template.Template, path string, data any) error {
 	return os.WriteFile(path, buf.Bytes(), fs.FileMode(0o644))
 }
 
-type frontMatter struct {
-	scalars map[string]string
-	lists   map[string][]string
-}
-
-func newFrontMatter() frontMatter {
-	return frontMatter{
-		scalars: make(map[string]string),
-		lists:   make(map[string][]string),
-	}
-}
-
-func (fm frontMatter) scalar(key string) string {
-	return fm.scalars[strings.ToLower(key)]
-}
-
-func (fm frontMatter) list(key string) []string {
-	return fm.lists[strings.ToLower(key)]
-}
-
-func extractFrontMatter(raw string) (frontMatter, string, error) {
-	raw = strings.TrimLeft(raw, "\ufeff")
-	if !strings.HasPrefix(raw, "---") {
-		return newFrontMatter(), strings.TrimSpace(raw), nil
-	}
-
-	remainder := raw[3:]
-	remainder = strings.TrimPrefix(remainder, "\r")
-	remainder = strings.TrimPrefix(remainder, "\n")
-
-	lines := strings.Split(remainder, "\n")
-	boundary := -1
-	for i, line := range lines {
-		if strings.TrimSpace(line) == "---" {
-			boundary = i
-			break
-		}
-	}
-	if boundary == -1 {
-		return newFrontMatter(), raw, errors.New("missing closing front matter delimiter")
-	}
-
-	metaLines := lines[:boundary]
-	bodyLines := lines[boundary+1:]
-
-	fm, err := parseFrontMatter(metaLines)
-	if err != nil {
-		return fm, "", err
-	}
-
-	body := strings.Join(bodyLines, "\n")
-	return fm, strings.TrimSpace(body), nil
-}
-
-func parseFrontMatter(lines []string) (frontMatter, error) {
-	fm := newFrontMatter()
-	var currentListKey string
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if trimmed == "" {
-			continue
-		}
-
-		if strings.HasPrefix(trimmed, "- ") {
-			if currentListKey == "" {
-				return fm, errors.New("list item outside a key")
-			}
-			value := strings.TrimSpace(trimmed[2:])
-			if value != "" {
-				key := strings.ToLower(currentListKey)
-				fm.lists[key] = append(fm.lists[key], value)
-			}
-			continue
-		}
-
-		currentListKey = ""
-		if idx := strings.Index(trimmed, ":"); idx >= 0 {
-			key := strings.ToLower(strings.TrimSpace(trimmed[:idx]))
-			value := strings.TrimSpace(trimmed[idx+1:])
-			if value == "" {
-				currentListKey = key
-				continue
-			}
-			fm.scalars[key] = value
-		}
-	}
-	return fm, nil
-}
-
-func markdownToHTML(md string) (template.HTML, string, string) {
-	lines := strings.Split(md, "\n")
-	var builder strings.Builder
-	var paragraph []string
-	var firstParagraph string
-	var title string
-	inList := false
-	const blockIndent = "      "
-
-	flushParagraph := func() {
-		if len(paragraph) == 0 {
-			return
-		}
-		raw := strings.Join(paragraph, " ")
-		if firstParagraph == "" {
-			firstParagraph = stripInline(raw)
-		}
-		builder.WriteString(blockIndent)
-		builder.WriteString("<p>")
-		builder.WriteString(renderInline(raw))
-		builder.WriteString("</p>\n")
-		paragraph = nil
-	}
-
-	closeList := func() {
-		if inList {
-			builder.WriteString(blockIndent)
-			builder.WriteString("</ul>\n")
-			inList = false
-		}
-	}
-
-	for _, line := range lines {
-		line = strings.TrimRight(line, " \t")
-		trimmed := strings.TrimSpace(line)
-		if trimmed == "" {
-			flushParagraph()
-			closeList()
-			continue
-		}
-
-		if strings.HasPrefix(trimmed, "#") {
-			level := headingLevel(trimmed)
-			if level > 0 {
-				flushParagraph()
-				closeList()
-				text := strings.TrimSpace(trimmed[level:])
-				if level == 1 && title == "" {
-					title = stripInline(text)
-					continue
-				}
-				builder.WriteString(fmt.Sprintf("%s<h%d>%s</h%d>\n", blockIndent, level, renderInline(text), level))
-				continue
-			}
-		}
-
-		if strings.HasPrefix(trimmed, "- ") {
-			flushParagraph()
-			if !inList {
-				builder.WriteString(blockIndent)
-				builder.WriteString("<ul>\n")
-				inList = true
-			}
-			builder.WriteString(blockIndent)
-			builder.WriteString("  <li>")
-			builder.WriteString(renderInline(strings.TrimSpace(trimmed[2:])))
-			builder.WriteString("</li>\n")
-			continue
-		}
-
-		paragraph = append(paragraph, trimmed)
-	}
-
-	flushParagraph()
-	closeList()
-
-	return template.HTML(builder.String()), strings.TrimSpace(firstParagraph), strings.TrimSpace(title)
-}
-
-func headingLevel(line string) int {
-	count := 0
-	for count < len(line) && line[count] == '#' {
-		count++
-	}
-	if count == 0 || count > 6 {
-		return 0
-	}
-	if len(line) <= count || line[count] != ' ' {
-		return 0
-	}
-	return count
-}
-
-func renderInline(input string) string {
-	var b strings.Builder
-	type marker struct {
-		tag string
-	}
-	var stack []marker
-
-	emit := func(s string) {
-		b.WriteString(s)
-	}
-
-	for i := 0; i < len(input); {
-		switch {
-		case strings.HasPrefix(input[i:], "**"):
-			if len(stack) > 0 && stack[len(stack)-1].tag == "strong" {
-				emit("</strong>")
-				stack = stack[:len(stack)-1]
-			} else {
-				stack = append(stack, marker{tag: "strong"})
-				emit("<strong>")
-			}
-			i += 2
-		case input[i] == '*' || input[i] == '_':
-			if len(stack) > 0 && stack[len(stack)-1].tag == "em" {
-				emit("</em>")
-				stack = stack[:len(stack)-1]
-			} else {
-				stack = append(stack, marker{tag: "em"})
-				emit("<em>")
-			}
-			i++
-		case input[i] == '`':
-			if len(stack) > 0 && stack[len(stack)-1].tag == "code" {
-				emit("</code>")
-				stack = stack[:len(stack)-1]
-			} else {
-				stack = append(stack, marker{tag: "code"})
-				emit("<code>")
-			}
-			i++
-		case input[i] == '[':
-			endText := strings.IndexByte(input[i:], ']')
-			if endText > 0 && i+endText+1 < len(input) && input[i+endText+1] == '(' {
-				endURL := strings.IndexByte(input[i+endText+2:], ')')
-				if endURL >= 0 {
-					text := input[i+1 : i+endText]
-					url := input[i+endText+2 : i+endText+2+endURL]
-					emit(`<a href="`)
-					emit(html.EscapeString(url))
-					emit(`">`)
-					emit(renderInline(text))
-					emit("</a>")
-					i += endText + 2 + endURL + 1
-					continue
-				}
-			}
-			fallthrough
-		default:
-			emit(escapeText(string(input[i])))
-			i++
-		}
-	}
-
-	for len(stack) > 0 {
-		switch stack[len(stack)-1].tag {
-		case "strong":
-			emit("</strong>")
-		case "em":
-			emit("</em>")
-		case "code":
-			emit("</code>")
-		}
-		stack = stack[:len(stack)-1]
-	}
-
-	return b.String()
-}
-
-func stripInline(s string) string {
-	replacer := strings.NewReplacer("**", "", "__", "", "*", "", "_", "", "`", "")
-	return replacer.Replace(s)
-}
-
 func escapeText(s string) string {
 	if !strings.ContainsAny(s, "&<>\"") {
 		return s
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"go.abhg.dev/goldmark/mermaid"
+)
+
+// defaultHighlightStyle is used when neither -highlight-style nor a post's
+// `highlight` front-matter key name a chroma theme.
+const defaultHighlightStyle = "monokai"
+
+// newMarkdown builds a Goldmark instance configured the way this blog wants
+// it: GFM (tables, task lists, strikethrough, autolinks), footnotes, chroma
+// syntax highlighting for fenced code blocks, and Mermaid diagram rendering
+// for ```mermaid fences.
+func newMarkdown(highlightStyle string) goldmark.Markdown {
+	if highlightStyle == "" {
+		highlightStyle = defaultHighlightStyle
+	}
+	return goldmark.New(
+		goldmark.WithExtensions(
+			extension.GFM,
+			extension.Footnote,
+			highlighting.NewHighlighting(
+				highlighting.WithStyle(highlightStyle),
+			),
+			&mermaid.Extender{},
+		),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+		),
+		goldmark.WithRendererOptions(
+			html.WithUnsafe(),
+		),
+	)
+}
+
+// renderMarkdown renders body to HTML with md, returning the text of the
+// first paragraph (for excerpts) and the first H1 heading (as an inferred
+// title, matching the old hand-rolled renderer's behavior). The H1, if
+// found, is removed from the rendered output since it belongs in page
+// chrome rather than post content. When toc is true, a table of contents
+// built from the post's H2/H3 headings is prepended to the content.
+func renderMarkdown(md goldmark.Markdown, body string, toc bool) (content template.HTML, firstParagraph, headingTitle string, err error) {
+	source := []byte(body)
+	doc := md.Parser().Parse(text.NewReader(source))
+
+	var headings []*ast.Heading
+	var titleNode *ast.Heading
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch node := n.(type) {
+		case *ast.Heading:
+			if node.Level == 1 && headingTitle == "" {
+				headingTitle = strings.TrimSpace(string(node.Text(source)))
+				titleNode = node
+			} else if node.Level >= 2 && node.Level <= 3 {
+				headings = append(headings, node)
+			}
+		case *ast.Paragraph:
+			if firstParagraph == "" {
+				firstParagraph = strings.TrimSpace(string(node.Text(source)))
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	// Removing a node must happen after the walk completes: doing it mid-walk
+	// corrupts the sibling chain the walker is traversing, silently dropping
+	// any headings that come after the title.
+	if titleNode != nil {
+		if parent := titleNode.Parent(); parent != nil {
+			parent.RemoveChild(parent, titleNode)
+		}
+	}
+
+	var buf bytes.Buffer
+	if toc && len(headings) > 0 {
+		buf.WriteString("<nav class=\"toc\">\n<ul>\n")
+		for _, h := range headings {
+			id, _ := h.AttributeString("id")
+			fmt.Fprintf(&buf, "<li><a href=\"#%s\">%s</a></li>\n", id, escapeText(string(h.Text(source))))
+		}
+		buf.WriteString("</ul>\n</nav>\n")
+	}
+
+	if err := md.Renderer().Render(&buf, source, doc); err != nil {
+		return "", "", "", err
+	}
+	return template.HTML(buf.String()), firstParagraph, headingTitle, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import "sync"
+
+// parallelMap applies fn to each item using up to jobs goroutines,
+// preserving input order in the result slice. jobs < 1 behaves as 1 (no
+// parallelism). The first error encountered is returned; all goroutines
+// still run to completion before it's surfaced.
+func parallelMap[T, R any](jobs int, items []T, fn func(T) (R, error)) ([]R, error) {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	results := make([]R, len(items))
+	errs := make([]error, len(items))
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+
+	for i, item := range items {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, item T) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			r, err := fn(item)
+			results[i] = r
+			errs[i] = err
+		}(i, item)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
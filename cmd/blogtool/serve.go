@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"gopkg.in/yaml.v3"
+)
+
+// runServe starts the `serve` subcommand: a Micropub server that lets
+// clients like iA Writer, Quill, and Indigenous publish posts into blogDir
+// without a git commit. Each accepted post is written as a Markdown file
+// and immediately rebuilt through the normal build pipeline.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	var blogDir, addr, tokenEndpoint, mediaDir, highlightStyle string
+	var siteURL, siteTitle, siteAuthor, siteLang string
+	fs.StringVar(&blogDir, "blog", "blog", "directory containing blog markdown files")
+	fs.StringVar(&addr, "addr", ":8080", "address to listen on")
+	fs.StringVar(&tokenEndpoint, "token-endpoint", "https://tokens.indieauth.com/token", "IndieAuth token endpoint used to validate bearer tokens")
+	fs.StringVar(&mediaDir, "media-dir", "", "directory for uploaded media (defaults to <blog>/media)")
+	fs.StringVar(&highlightStyle, "highlight-style", defaultHighlightStyle, "chroma theme used to highlight fenced code blocks")
+	fs.StringVar(&siteURL, "site-url", "", "absolute base URL of the site, e.g. https://example.com")
+	fs.StringVar(&siteTitle, "site-title", "Blog", "site title used in the generated feeds")
+	fs.StringVar(&siteAuthor, "site-author", "", "default author name used in the generated feeds")
+	fs.StringVar(&siteLang, "site-lang", "en", "site language code used in the generated feeds")
+	fs.Parse(args)
+
+	if mediaDir == "" {
+		mediaDir = filepath.Join(blogDir, "media")
+	}
+	if err := os.MkdirAll(mediaDir, 0o755); err != nil {
+		return fmt.Errorf("create media dir: %w", err)
+	}
+
+	s, err := loadSite(blogDir, siteURL, siteTitle, siteAuthor, siteLang)
+	if err != nil {
+		return fmt.Errorf("load site config: %w", err)
+	}
+
+	srv := &micropubServer{
+		blogDir:        blogDir,
+		mediaDir:       mediaDir,
+		tokenEndpoint:  tokenEndpoint,
+		md:             newMarkdown(highlightStyle),
+		highlightStyle: highlightStyle,
+		site:           s,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/micropub", srv.handleMicropub)
+	mux.HandleFunc("/micropub/media", srv.handleMedia)
+
+	fmt.Fprintf(os.Stdout, "micropub server listening on %s\n", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// micropubServer implements the Micropub endpoint and media endpoint
+// against a single blogDir, rebuilding the static site after every accepted
+// post.
+type micropubServer struct {
+	blogDir        string
+	mediaDir       string
+	tokenEndpoint  string
+	md             goldmark.Markdown
+	highlightStyle string
+	site           site
+}
+
+func (s *micropubServer) handleMicropub(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleQuery(w, r)
+	case http.MethodPost:
+		s.handlePost(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *micropubServer) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.authenticate(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Query().Get("q") {
+	case "config":
+		writeJSONResponse(w, map[string]any{
+			"media-endpoint": "/micropub/media",
+		})
+	case "source":
+		p, err := s.loadPostByURL(r.URL.Query().Get("url"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSONResponse(w, map[string]any{
+			"type": []string{"h-entry"},
+			"properties": map[string]any{
+				"name":      []string{p.Title},
+				"content":   []string{string(p.Content)},
+				"published": []string{p.DateISO},
+				"category":  p.Tags,
+			},
+		})
+	default:
+		http.Error(w, "unsupported query", http.StatusBadRequest)
+	}
+}
+
+func (s *micropubServer) handlePost(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.authenticate(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	entry, err := parseMicropubEntry(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if entry.Title == "" && entry.Content == "" && entry.InReplyTo == "" && entry.LikeOf == "" && entry.RepostOf == "" {
+		http.Error(w, "missing name, content, in-reply-to, like-of, or repost-of", http.StatusBadRequest)
+		return
+	}
+
+	slug, err := s.writeEntry(entry)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.rebuild(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", s.site.absHref("blog/"+slug+".html"))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleMedia implements the Micropub media endpoint: a single uploaded
+// file is stored under blog/media/ and its location returned for the
+// client to reference from a post.
+func (s *micropubServer) handleMedia(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := s.authenticate(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if err := r.ParseMultipartForm(20 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	name := fmt.Sprintf("%d-%s", time.Now().UnixNano(), filepath.Base(header.Filename))
+	dst, err := os.Create(filepath.Join(s.mediaDir, name))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer dst.Close()
+	if _, err := io.Copy(dst, file); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", s.site.absHref("blog/media/"+name))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// authenticate validates the request's bearer token against the configured
+// IndieAuth token endpoint and returns the authenticated "me" URL.
+func (s *micropubServer) authenticate(r *http.Request) (string, error) {
+	token := bearerToken(r)
+	if token == "" {
+		return "", errors.New("missing bearer token")
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, s.tokenEndpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("validate token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint rejected token: %s", resp.Status)
+	}
+
+	var body struct {
+		Me string `json:"me"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decode token response: %w", err)
+	}
+	if body.Me == "" {
+		return "", errors.New("token endpoint response missing \"me\"")
+	}
+	if !s.ownsIdentity(body.Me) {
+		return "", fmt.Errorf("token endpoint authenticated %q, not this site's owner", body.Me)
+	}
+	return body.Me, nil
+}
+
+// ownsIdentity reports whether me (the "me" claim the token endpoint
+// authenticated) is this site's own -site-url/site.yml identity. The token
+// endpoint only proves who the bearer authenticated as, not that they're
+// authorized to publish here — tokens.indieauth.com is a public multi-tenant
+// service, so without this check anyone who has ever authenticated there for
+// their own site could publish to this one.
+func (s *micropubServer) ownsIdentity(me string) bool {
+	return s.site.URL != "" && strings.TrimSuffix(me, "/") == strings.TrimSuffix(s.site.URL, "/")
+}
+
+func bearerToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.FormValue("access_token")
+}
+
+// rebuild re-runs the normal build pipeline so a newly published post shows
+// up on the site immediately. It reuses the on-disk build cache, so only
+// the post just written actually gets re-rendered.
+func (s *micropubServer) rebuild() error {
+	posts, err := loadPosts(s.blogDir, s.md, 1, defaultSitemapPriority, defaultSitemapChangefreq, s.highlightStyle)
+	if err != nil {
+		return err
+	}
+
+	cache, err := loadBuildCache(s.blogDir)
+	if err != nil {
+		return err
+	}
+	if err := writePosts(posts, cache, false, 1); err != nil {
+		return err
+	}
+	if err := cache.save(); err != nil {
+		return err
+	}
+
+	if err := writeIndex(s.blogDir, posts); err != nil {
+		return err
+	}
+	if err := writeFeeds(s.blogDir, posts, s.site); err != nil {
+		return err
+	}
+
+	tags, err := writeTagPages(s.blogDir, posts)
+	if err != nil {
+		return err
+	}
+	return writeSitemap(s.blogDir, posts, tags, s.site, defaultSitemapPriority, defaultSitemapChangefreq)
+}
+
+func (s *micropubServer) loadPostByURL(rawURL string) (*post, error) {
+	posts, err := loadPosts(s.blogDir, s.md, 1, defaultSitemapPriority, defaultSitemapChangefreq, s.highlightStyle)
+	if err != nil {
+		return nil, err
+	}
+	slug := strings.TrimSuffix(filepath.Base(rawURL), filepath.Ext(rawURL))
+	for _, p := range posts {
+		if p.Slug == slug {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("no post found for %q", rawURL)
+}
+
+// writeEntry materializes a Micropub entry as a Markdown file with YAML
+// front matter and returns its slug.
+func (s *micropubServer) writeEntry(entry micropubEntry) (slug string, err error) {
+	meta := map[string]any{
+		"date": entry.Date.Format("2006-01-02"),
+	}
+	if entry.Title != "" {
+		meta["title"] = entry.Title
+	}
+	if len(entry.Tags) > 0 {
+		meta["tags"] = entry.Tags
+	}
+	if len(entry.Authors) > 0 {
+		meta["authors"] = entry.Authors
+	}
+	if entry.InReplyTo != "" {
+		meta["in-reply-to"] = entry.InReplyTo
+	}
+	if entry.LikeOf != "" {
+		meta["like-of"] = entry.LikeOf
+	}
+	if entry.RepostOf != "" {
+		meta["repost-of"] = entry.RepostOf
+	}
+
+	fmBytes, err := yaml.Marshal(meta)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	buf.Write(fmBytes)
+	buf.WriteString("---\n\n")
+	buf.WriteString(entry.Content)
+	buf.WriteString("\n")
+
+	return s.writeUniqueEntry(slugify(entry.Title, entry.Date), buf.Bytes())
+}
+
+// micropubEntry is the provider-agnostic result of parsing a Micropub
+// create request, whatever its wire format.
+type micropubEntry struct {
+	Title     string
+	Content   string
+	Tags      []string
+	Authors   []string
+	Date      time.Time
+	InReplyTo string
+	LikeOf    string
+	RepostOf  string
+}
+
+// parseMicropubEntry accepts the three content types the Micropub spec
+// requires: application/x-www-form-urlencoded, multipart/form-data, and
+// application/json (the "mf2-json" post type).
+func parseMicropubEntry(r *http.Request) (micropubEntry, error) {
+	contentType := r.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, "application/json"):
+		return parseJSONEntry(r)
+	case strings.HasPrefix(contentType, "multipart/form-data"):
+		if err := r.ParseMultipartForm(20 << 20); err != nil {
+			return micropubEntry{}, fmt.Errorf("parse multipart form: %w", err)
+		}
+		return parseFormEntry(r.Form), nil
+	default:
+		if err := r.ParseForm(); err != nil {
+			return micropubEntry{}, fmt.Errorf("parse form: %w", err)
+		}
+		return parseFormEntry(r.Form), nil
+	}
+}
+
+func parseFormEntry(form url.Values) micropubEntry {
+	return micropubEntry{
+		Title:     form.Get("name"),
+		Content:   form.Get("content"),
+		Tags:      form["category[]"],
+		Authors:   form["author[]"],
+		Date:      time.Now(),
+		InReplyTo: form.Get("in-reply-to"),
+		LikeOf:    form.Get("like-of"),
+		RepostOf:  form.Get("repost-of"),
+	}
+}
+
+func parseJSONEntry(r *http.Request) (micropubEntry, error) {
+	var doc struct {
+		Properties map[string][]any `json:"properties"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		return micropubEntry{}, fmt.Errorf("decode json: %w", err)
+	}
+
+	prop := func(key string) string {
+		if vs := doc.Properties[key]; len(vs) > 0 {
+			if s, ok := vs[0].(string); ok {
+				return s
+			}
+		}
+		return ""
+	}
+	propAll := func(key string) []string {
+		var out []string
+		for _, v := range doc.Properties[key] {
+			if s, ok := v.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+
+	return micropubEntry{
+		Title:     prop("name"),
+		Content:   prop("content"),
+		Tags:      propAll("category"),
+		Authors:   propAll("author"),
+		Date:      time.Now(),
+		InReplyTo: prop("in-reply-to"),
+		LikeOf:    prop("like-of"),
+		RepostOf:  prop("repost-of"),
+	}, nil
+}
+
+func writeJSONResponse(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// slugify derives a dated slug from a post title, falling back to "note"
+// for titleless posts (likes, reposts, short notes).
+func slugify(title string, date time.Time) string {
+	base := slugifyText(title)
+	if base == "" {
+		base = "note"
+	}
+	return date.Format("2006-01-02") + "-" + base
+}
+
+// writeUniqueEntry writes data to blogDir/<base>.md, or blogDir/<base>-2.md,
+// blogDir/<base>-3.md, ... if that file already exists. Opening with
+// O_EXCL makes the check-then-write atomic, so two concurrent posts that
+// slugify to the same base (e.g. two untitled likes on the same day) can't
+// both pass a stat check for the same candidate and clobber each other.
+func (s *micropubServer) writeUniqueEntry(base string, data []byte) (string, error) {
+	candidate := base
+	for n := 2; ; n++ {
+		path := filepath.Join(s.blogDir, candidate+".md")
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			_, writeErr := f.Write(data)
+			closeErr := f.Close()
+			if writeErr != nil {
+				return "", writeErr
+			}
+			if closeErr != nil {
+				return "", closeErr
+			}
+			return candidate, nil
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s-%d", base, n)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/xml"
+	"path/filepath"
+)
+
+type sitemapURL struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod,omitempty"`
+	ChangeFreq string `xml:"changefreq,omitempty"`
+	Priority   string `xml:"priority,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// writeSitemap emits blog/sitemap.xml (Sitemaps 0.9) covering the blog
+// index, every post (with its per-post or default <changefreq>/<priority>),
+// and every tag page.
+func writeSitemap(blogDir string, posts []*post, tags []tagArchive, s site, defaultPriority, defaultChangefreq string) error {
+	set := sitemapURLSet{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+
+	set.URLs = append(set.URLs, sitemapURL{
+		Loc:        s.absHref("blog/"),
+		LastMod:    feedUpdated(posts).Format("2006-01-02"),
+		ChangeFreq: defaultChangefreq,
+		Priority:   defaultPriority,
+	})
+
+	for _, p := range posts {
+		set.URLs = append(set.URLs, sitemapURL{
+			Loc:        s.absHref("blog/" + p.Href),
+			LastMod:    p.Date.Format("2006-01-02"),
+			ChangeFreq: p.SitemapChangefreq,
+			Priority:   p.SitemapPriority,
+		})
+	}
+
+	if len(tags) > 0 {
+		set.URLs = append(set.URLs, sitemapURL{
+			Loc:        s.absHref("blog/tags/"),
+			ChangeFreq: defaultChangefreq,
+			Priority:   defaultPriority,
+		})
+	}
+	for _, t := range tags {
+		set.URLs = append(set.URLs, sitemapURL{
+			Loc:        s.absHref("blog/tags/" + t.Slug + ".html"),
+			ChangeFreq: defaultChangefreq,
+			Priority:   defaultPriority,
+		})
+	}
+
+	return writeXML(filepath.Join(blogDir, "sitemap.xml"), set)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// slugifyText lowercases s and collapses runs of non-alphanumeric
+// characters into single hyphens, trimming leading/trailing hyphens. It's
+// the shared basis for post slugs (serve.go) and tag slugs (tags.go).
+func slugifyText(s string) string {
+	base := strings.ToLower(strings.TrimSpace(s))
+	base = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			return r
+		case r == ' ' || r == '-' || r == '_':
+			return '-'
+		default:
+			return -1
+		}
+	}, base)
+	for strings.Contains(base, "--") {
+		base = strings.ReplaceAll(base, "--", "-")
+	}
+	return strings.Trim(base, "-")
+}
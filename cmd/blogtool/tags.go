@@ -0,0 +1,80 @@
+package main
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var (
+	tagTpl      = template.Must(template.New("tag.tmpl").Funcs(templateFuncs).ParseFS(templateFS, "templates/tag.tmpl"))
+	tagIndexTpl = template.Must(template.New("tag-index.tmpl").Funcs(templateFuncs).ParseFS(templateFS, "templates/tag-index.tmpl"))
+)
+
+// tagArchive is a single tag's archive page: the tag's name, slug, and the
+// posts carrying it (in the same newest-first order as the main index).
+type tagArchive struct {
+	Name  string
+	Slug  string
+	Posts []*post
+}
+
+// tagSummary is one row of the tags index page.
+type tagSummary struct {
+	Name  string
+	Slug  string
+	Count int
+	Href  string
+}
+
+func groupByTag(posts []*post) []tagArchive {
+	bySlug := map[string]*tagArchive{}
+	var slugs []string
+	for _, p := range posts {
+		for _, tag := range p.Tags {
+			slug := slugifyText(tag)
+			a, ok := bySlug[slug]
+			if !ok {
+				a = &tagArchive{Name: tag, Slug: slug}
+				bySlug[slug] = a
+				slugs = append(slugs, slug)
+			}
+			a.Posts = append(a.Posts, p)
+		}
+	}
+	sort.Strings(slugs)
+
+	archives := make([]tagArchive, 0, len(slugs))
+	for _, slug := range slugs {
+		archives = append(archives, *bySlug[slug])
+	}
+	return archives
+}
+
+// writeTagPages renders blog/tags/<slug>.html for every tag used by posts,
+// plus blog/tags/index.html listing all tags with their post counts. It
+// returns the archives generated, for writeSitemap to include.
+func writeTagPages(blogDir string, posts []*post) ([]tagArchive, error) {
+	archives := groupByTag(posts)
+
+	tagsDir := filepath.Join(blogDir, "tags")
+	if err := os.MkdirAll(tagsDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	summaries := make([]tagSummary, 0, len(archives))
+	for _, a := range archives {
+		target := filepath.Join(tagsDir, a.Slug+".html")
+		if err := renderTemplate(tagTpl, target, a); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, tagSummary{Name: a.Name, Slug: a.Slug, Count: len(a.Posts), Href: a.Slug + ".html"})
+	}
+
+	data := struct{ Tags []tagSummary }{Tags: summaries}
+	if err := renderTemplate(tagIndexTpl, filepath.Join(tagsDir, "index.html"), data); err != nil {
+		return nil, err
+	}
+	return archives, nil
+}